@@ -0,0 +1,97 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package reqctx
+
+import "context"
+
+// CacheStats records the effectiveness of the per-request memoization cache.
+// Use CacheStatsData to read a snapshot for the current request.
+type CacheStats struct {
+	Hit, Miss int64
+}
+
+// CacheStatsData returns a snapshot of the current request's cache hit/miss
+// counters. It returns a zero CacheStats if ctx carries no RequestDataStore.
+func CacheStatsData(ctx context.Context) CacheStats {
+	store := getDataStore(ctx)
+	if store == nil {
+		return CacheStats{}
+	}
+	return store.cacheStatsSnapshot()
+}
+
+type cacheDisabledKeyType struct{}
+
+var cacheDisabledKey cacheDisabledKeyType
+
+// WithCacheDisabled returns a context whose request-scoped cache is bypassed:
+// GetWithCache still runs the loader, but nothing is read from or written to
+// the cache. Use it for hot paths that shouldn't pollute the request cache
+// with one-off data.
+func WithCacheDisabled(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheDisabledKey, true)
+}
+
+func isCacheDisabled(ctx context.Context) bool {
+	disabled, _ := ctx.Value(cacheDisabledKey).(bool)
+	return disabled
+}
+
+// SetCacheData stores value in the request-scoped cache under kind/key.
+// kind is usually the name of the model being cached (e.g. "user.User"),
+// to keep keys from different packages from colliding. It is a no-op if ctx
+// carries no RequestDataStore.
+func SetCacheData(ctx context.Context, kind string, key, value any) {
+	if store := getDataStore(ctx); store != nil {
+		store.setCacheData(kind, key, value)
+	}
+}
+
+// GetCacheData returns the cached value for kind/key and whether it was found.
+func GetCacheData(ctx context.Context, kind string, key any) (any, bool) {
+	if store := getDataStore(ctx); store != nil {
+		return store.getCacheData(kind, key)
+	}
+	return nil, false
+}
+
+// RemoveCacheData drops a previously cached value, so the next GetWithCache
+// call for the same kind/key reloads it. Callers should call this after
+// mutating the underlying record so later reads within the same request see
+// the change.
+func RemoveCacheData(ctx context.Context, kind string, key any) {
+	if store := getDataStore(ctx); store != nil {
+		store.removeCacheData(kind, key)
+	}
+}
+
+// GetWithCache memoizes loader's result within the current request: the
+// first call for a given kind/key runs loader and caches the result, later
+// calls for the same kind/key within the same request reuse it without
+// calling loader again. If ctx carries no RequestDataStore (e.g. a
+// background job) or caching was disabled via WithCacheDisabled, loader
+// always runs and nothing is cached.
+func GetWithCache[T any](ctx context.Context, kind string, key any, loader func(ctx context.Context) (T, error)) (T, error) {
+	store := getDataStore(ctx)
+	if store == nil || isCacheDisabled(ctx) {
+		return loader(ctx)
+	}
+	if v, ok := store.getCacheData(kind, key); ok {
+		if tv, ok := v.(T); ok {
+			return tv, nil
+		}
+	}
+	v, err := loader(ctx)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	store.setCacheData(kind, key, v)
+	return v, nil
+}
+
+func getDataStore(ctx context.Context) *requestDataStore {
+	store, _ := GetRequestDataStore(ctx).(*requestDataStore)
+	return store
+}
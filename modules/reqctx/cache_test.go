@@ -0,0 +1,81 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package reqctx
+
+import (
+	"context"
+	"testing"
+)
+
+func TestGetWithCache(t *testing.T) {
+	ctx := NewRequestContextForTest(context.Background())
+
+	calls := 0
+	loader := func(context.Context) (int, error) {
+		calls++
+		return 42, nil
+	}
+
+	v, err := GetWithCache(ctx, "kind", "key", loader)
+	if err != nil || v != 42 {
+		t.Fatalf("first GetWithCache: got (%v, %v), want (42, nil)", v, err)
+	}
+	v, err = GetWithCache(ctx, "kind", "key", loader)
+	if err != nil || v != 42 {
+		t.Fatalf("second GetWithCache: got (%v, %v), want (42, nil)", v, err)
+	}
+	if calls != 1 {
+		t.Fatalf("loader called %d times, want 1 (second call should be a cache hit)", calls)
+	}
+
+	stats := CacheStatsData(ctx)
+	if stats.Hit != 1 || stats.Miss != 1 {
+		t.Fatalf("CacheStatsData = %+v, want Hit=1 Miss=1", stats)
+	}
+
+	RemoveCacheData(ctx, "kind", "key")
+	if _, err = GetWithCache(ctx, "kind", "key", loader); err != nil {
+		t.Fatalf("GetWithCache after RemoveCacheData: %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("loader called %d times after RemoveCacheData, want 2", calls)
+	}
+}
+
+func TestGetWithCache_NoRequestDataStore(t *testing.T) {
+	calls := 0
+	loader := func(context.Context) (int, error) {
+		calls++
+		return 7, nil
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if v, err := GetWithCache(ctx, "kind", "key", loader); err != nil || v != 7 {
+			t.Fatalf("GetWithCache: got (%v, %v), want (7, nil)", v, err)
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("loader called %d times without a RequestDataStore, want 2 (no caching)", calls)
+	}
+}
+
+func TestGetWithCache_Disabled(t *testing.T) {
+	ctx := NewRequestContextForTest(context.Background())
+	ctx = WithCacheDisabled(ctx)
+
+	calls := 0
+	loader := func(context.Context) (int, error) {
+		calls++
+		return 1, nil
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := GetWithCache(ctx, "kind", "key", loader); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("loader called %d times with caching disabled, want 2", calls)
+	}
+}
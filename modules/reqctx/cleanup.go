@@ -0,0 +1,133 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package reqctx
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"code.gitea.io/gitea/modules/log"
+)
+
+// These are vars rather than consts so tests can shrink them to keep a
+// timeout test fast; production code should treat them as constants.
+var (
+	// perCleanUpTimeout bounds how long a single cleanup function may run. A
+	// cleanup that hangs (e.g. a git process or a leaked pipe reader) is
+	// abandoned after this and reported as timed out, instead of blocking
+	// request teardown indefinitely.
+	perCleanUpTimeout = 10 * time.Second
+	// totalCleanUpTimeout bounds all cleanups for one request put together.
+	totalCleanUpTimeout = 30 * time.Second
+	// slowCleanUpThreshold is the duration above which a single cleanup is logged.
+	slowCleanUpThreshold = time.Second
+)
+
+type cleanUpEntry struct {
+	name string
+	f    func() error
+}
+
+// CleanUpResult records the outcome of a single cleanup function run at request end.
+type CleanUpResult struct {
+	Name     string
+	Duration time.Duration
+	Err      error
+	TimedOut bool
+}
+
+// AddCleanUp adds a cleanup function which will be called at the end of the request,
+// in LIFO order (matching `defer` semantics). Prefer AddCleanUpWithName when the
+// cleanup can fail and the error is worth recording.
+func (r *requestDataStore) AddCleanUp(f func()) {
+	r.AddCleanUpWithName("", func() error {
+		f()
+		return nil
+	})
+}
+
+// AddCleanUpWithName adds a named, error-returning cleanup function which will be
+// called at the end of the request, in LIFO order. The name shows up in
+// CleanUpResults and in the log line emitted if the cleanup is slow or times out.
+func (r *requestDataStore) AddCleanUpWithName(name string, f func() error) {
+	r.mu.Lock()
+	r.cleanUps = append(r.cleanUps, cleanUpEntry{name: name, f: f})
+	r.mu.Unlock()
+}
+
+// AddCloser registers c to be closed at request end, same as AddCleanUp. If c
+// also implements Sized, its size is metered against DimensionTempFileBytes.
+// This is after-the-fact accounting, not enforcement: c has already been
+// created by the time AddCloser sees it, so an ErrQuotaExceeded here only
+// logs a warning and does not reject c or abort the request. Callers that
+// need to actually stop a caller from exceeding a quota (e.g. before
+// allocating a large temp file) must call Meter themselves beforehand and
+// act on the error.
+func (r *requestDataStore) AddCloser(c io.Closer) {
+	if sized, ok := c.(Sized); ok {
+		if err := r.quota.meter(DimensionTempFileBytes, sized.Size()); err != nil {
+			log.Warn("reqctx: %v", err)
+		}
+	}
+	name := fmt.Sprintf("close %T", c)
+	r.AddCleanUpWithName(name, c.Close)
+}
+
+// cleanUp runs all registered cleanups in LIFO order, each bounded by
+// perCleanUpTimeout, and records the results. ctx should already carry an
+// overall deadline (totalCleanUpTimeout) since the request's own context is
+// canceled by the time this runs.
+func (r *requestDataStore) cleanUp(ctx context.Context, profDesc string) {
+	r.mu.Lock()
+	cleanUps := r.cleanUps
+	r.cleanUps = nil
+	r.mu.Unlock()
+
+	results := make([]CleanUpResult, 0, len(cleanUps))
+	for i := len(cleanUps) - 1; i >= 0; i-- {
+		results = append(results, runCleanUp(ctx, cleanUps[i]))
+	}
+
+	r.mu.Lock()
+	r.cleanUpResults = results
+	r.cacheData = nil // drop any cached blobs promptly, the store itself is about to go out of scope anyway
+	r.mu.Unlock()
+
+	for _, res := range results {
+		if res.Err != nil || res.Duration >= slowCleanUpThreshold {
+			log.Warn("reqctx: %s: cleanup %q took %v, err=%v", profDesc, res.Name, res.Duration, res.Err)
+		}
+	}
+}
+
+func runCleanUp(ctx context.Context, entry cleanUpEntry) CleanUpResult {
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- entry.f() }()
+
+	cleanUpCtx, cancel := context.WithTimeout(ctx, perCleanUpTimeout)
+	defer cancel()
+
+	select {
+	case err := <-done:
+		return CleanUpResult{Name: entry.name, Duration: time.Since(start), Err: err}
+	case <-cleanUpCtx.Done():
+		return CleanUpResult{Name: entry.name, Duration: time.Since(start), Err: cleanUpCtx.Err(), TimedOut: true}
+	}
+}
+
+// CleanUpResults returns the outcome of each cleanup function run at the end of the
+// current request, for the routing logger to record. It returns nil if ctx carries
+// no RequestDataStore or the request hasn't finished yet.
+func CleanUpResults(ctx context.Context) []CleanUpResult {
+	store := getDataStore(ctx)
+	if store == nil {
+		return nil
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return store.cleanUpResults
+}
@@ -0,0 +1,74 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package reqctx
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCleanUp_LIFOOrder(t *testing.T) {
+	store := &requestDataStore{}
+	var order []string
+	for _, name := range []string{"a", "b", "c"} {
+		name := name
+		store.AddCleanUpWithName(name, func() error {
+			order = append(order, name)
+			return nil
+		})
+	}
+	store.cleanUp(context.Background(), "test")
+
+	want := []string{"c", "b", "a"}
+	if !reflect.DeepEqual(order, want) {
+		t.Fatalf("cleanup order = %v, want %v", order, want)
+	}
+}
+
+func TestCleanUp_CollectsErrors(t *testing.T) {
+	store := &requestDataStore{}
+	boom := errors.New("boom")
+	store.AddCleanUpWithName("ok", func() error { return nil })
+	store.AddCleanUpWithName("bad", func() error { return boom })
+
+	store.cleanUp(context.Background(), "test")
+
+	results := store.cleanUpResults
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	// LIFO: "bad" runs first.
+	if results[0].Name != "bad" || !errors.Is(results[0].Err, boom) {
+		t.Fatalf("results[0] = %+v, want Name=bad Err=boom", results[0])
+	}
+	if results[1].Name != "ok" || results[1].Err != nil {
+		t.Fatalf("results[1] = %+v, want Name=ok Err=nil", results[1])
+	}
+}
+
+func TestCleanUp_Timeout(t *testing.T) {
+	origPer := perCleanUpTimeout
+	perCleanUpTimeout = 20 * time.Millisecond
+	defer func() { perCleanUpTimeout = origPer }()
+
+	store := &requestDataStore{}
+	store.AddCleanUpWithName("hangs", func() error {
+		time.Sleep(200 * time.Millisecond)
+		return nil
+	})
+
+	start := time.Now()
+	store.cleanUp(context.Background(), "test")
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("cleanUp took %v, want it to give up around perCleanUpTimeout", elapsed)
+	}
+
+	results := store.cleanUpResults
+	if len(results) != 1 || !results[0].TimedOut {
+		t.Fatalf("cleanUpResults = %+v, want a single timed-out result", results)
+	}
+}
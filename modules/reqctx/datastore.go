@@ -7,6 +7,7 @@ import (
 	"context"
 	"io"
 	"sync"
+	"time"
 
 	"code.gitea.io/gitea/modules/process"
 )
@@ -34,6 +35,7 @@ type RequestDataStore interface {
 	SetContextValue(k, v any)
 	GetContextValue(key any) any
 	AddCleanUp(f func())
+	AddCleanUpWithName(name string, f func() error)
 	AddCloser(c io.Closer)
 }
 
@@ -44,9 +46,17 @@ var RequestDataStoreKey requestDataStoreKeyType
 type requestDataStore struct {
 	data ContextData
 
-	mu           sync.RWMutex
-	values       map[any]any
-	cleanUpFuncs []func()
+	mu             sync.RWMutex
+	values         map[any]any
+	cleanUps       []cleanUpEntry
+	cleanUpResults []CleanUpResult
+
+	cacheData  map[string]map[any]any
+	cacheStats CacheStats
+
+	events eventLog
+	quota  quota
+	spans  spanTracker
 }
 
 func (r *requestDataStore) GetContextValue(key any) any {
@@ -72,20 +82,43 @@ func (r *requestDataStore) GetData() ContextData {
 	return r.data
 }
 
-func (r *requestDataStore) AddCleanUp(f func()) {
+func (r *requestDataStore) getCacheData(kind string, key any) (any, bool) {
+	// Full Lock, not RLock: this mutates cacheStats, and RLock permits concurrent
+	// holders which would race on that mutation.
 	r.mu.Lock()
-	r.cleanUpFuncs = append(r.cleanUpFuncs, f)
-	r.mu.Unlock()
+	defer r.mu.Unlock()
+	v, ok := r.cacheData[kind][key]
+	if ok {
+		r.cacheStats.Hit++
+	} else {
+		r.cacheStats.Miss++
+	}
+	return v, ok
 }
 
-func (r *requestDataStore) AddCloser(c io.Closer) {
-	r.AddCleanUp(func() { _ = c.Close() })
+// cacheStatsSnapshot returns a copy of the current cache hit/miss counters.
+func (r *requestDataStore) cacheStatsSnapshot() CacheStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cacheStats
 }
 
-func (r *requestDataStore) cleanUp() {
-	for _, f := range r.cleanUpFuncs {
-		f()
+func (r *requestDataStore) setCacheData(kind string, key, value any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cacheData == nil {
+		r.cacheData = make(map[string]map[any]any)
+	}
+	if r.cacheData[kind] == nil {
+		r.cacheData[kind] = make(map[any]any)
 	}
+	r.cacheData[kind][key] = value
+}
+
+func (r *requestDataStore) removeCacheData(kind string, key any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.cacheData[kind], key)
 }
 
 func GetRequestDataStore(ctx context.Context) RequestDataStore {
@@ -110,8 +143,17 @@ func (c *requestContext) Value(key any) any {
 func NewRequestContext(parentCtx context.Context, profDesc string) (_ context.Context, finished func()) {
 	ctx, _, processFinished := process.GetManager().AddTypedContext(parentCtx, profDesc, process.RequestProcessType, true)
 	reqCtx := &requestContext{Context: ctx, dataStore: &requestDataStore{values: make(map[any]any)}}
+	reqStart := time.Now()
 	return reqCtx, func() {
-		reqCtx.dataStore.cleanUp()
+		reqDuration := time.Since(reqStart)
+		logRequestEvents(profDesc, reqCtx.dataStore.events.snapshot())
+		logRequestSpans(profDesc, reqCtx.dataStore.spans.takeRoots(), reqDuration)
+		// The request context is already canceled by the time finish() runs, so the
+		// deadline that bounds all cleanups comes from a fresh context instead; the
+		// cleanup functions themselves are not passed a context.
+		cleanUpCtx, cancel := context.WithTimeout(context.Background(), totalCleanUpTimeout)
+		reqCtx.dataStore.cleanUp(cleanUpCtx, profDesc)
+		cancel()
 		processFinished()
 	}
 }
@@ -0,0 +1,105 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package reqctx
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"code.gitea.io/gitea/modules/log"
+)
+
+// slowRequestEventsThreshold is the total recorded-event duration above which
+// the full event list is logged in addition to the per-category summary.
+const slowRequestEventsThreshold = 5 * time.Second
+
+// Event is one entry in a request's structured activity log, recording what
+// the request did (e.g. a DB query, a git operation, a cache lookup) so the
+// routing logger can show "what did this one request actually do" for slow
+// or failed requests.
+type Event struct {
+	Time     time.Time
+	Category string // e.g. "db", "git", "cache", "render"
+	Op       string
+	Duration time.Duration
+	Attrs    map[string]any
+}
+
+// eventLog is kept separate from requestDataStore.values/mu so that appending
+// events on hot paths never contends with SetContextValue/GetContextValue.
+type eventLog struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (l *eventLog) append(e Event) {
+	l.mu.Lock()
+	l.events = append(l.events, e)
+	l.mu.Unlock()
+}
+
+func (l *eventLog) snapshot() []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]Event, len(l.events))
+	copy(out, l.events)
+	return out
+}
+
+// AppendEvent records an event in the current request's activity log. It is
+// a no-op if ctx carries no RequestDataStore.
+func AppendEvent(ctx context.Context, category, op string, duration time.Duration, attrs map[string]any) {
+	store := getDataStore(ctx)
+	if store == nil {
+		return
+	}
+	store.events.append(Event{Time: time.Now(), Category: category, Op: op, Duration: duration, Attrs: attrs})
+}
+
+// Events returns a snapshot of all events recorded so far in the current
+// request, in the order they were appended. It returns nil if ctx carries no
+// RequestDataStore.
+func Events(ctx context.Context) []Event {
+	store := getDataStore(ctx)
+	if store == nil {
+		return nil
+	}
+	return store.events.snapshot()
+}
+
+// EventSummary aggregates Events by category, for a single-line end-of-request log.
+type EventSummary struct {
+	Count    int
+	Duration time.Duration
+}
+
+// SummarizeEvents aggregates events by category for a compact end-of-request log line.
+func SummarizeEvents(events []Event) map[string]EventSummary {
+	summary := make(map[string]EventSummary, len(events))
+	for _, e := range events {
+		s := summary[e.Category]
+		s.Count++
+		s.Duration += e.Duration
+		summary[e.Category] = s
+	}
+	return summary
+}
+
+// logRequestEvents emits the aggregated per-category summary for a finished
+// request, and the full event list as well if the request was slow enough
+// that operators are likely to want to see exactly what it did.
+func logRequestEvents(profDesc string, events []Event) {
+	if len(events) == 0 {
+		return
+	}
+	var total time.Duration
+	for _, e := range events {
+		total += e.Duration
+	}
+	log.Debug("reqctx: %s finished, events=%v", profDesc, SummarizeEvents(events))
+	if total >= slowRequestEventsThreshold {
+		log.Warn("reqctx: %s was slow (events total %v), full event list: %+v", profDesc, total, events)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package reqctx
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAppendEvent_AndEvents(t *testing.T) {
+	ctx := NewRequestContextForTest(context.Background())
+
+	AppendEvent(ctx, "db", "SelectUser", 5*time.Millisecond, map[string]any{"id": 1})
+	AppendEvent(ctx, "git", "CatFile", 10*time.Millisecond, nil)
+
+	events := Events(ctx)
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+	if events[0].Category != "db" || events[0].Op != "SelectUser" || events[0].Duration != 5*time.Millisecond {
+		t.Fatalf("events[0] = %+v, want category=db op=SelectUser duration=5ms", events[0])
+	}
+	if events[1].Category != "git" || events[1].Op != "CatFile" {
+		t.Fatalf("events[1] = %+v, want category=git op=CatFile", events[1])
+	}
+}
+
+func TestAppendEvent_NoRequestDataStore(t *testing.T) {
+	ctx := context.Background()
+	AppendEvent(ctx, "db", "SelectUser", time.Millisecond, nil)
+
+	if events := Events(ctx); events != nil {
+		t.Fatalf("Events without a RequestDataStore = %+v, want nil", events)
+	}
+}
+
+func TestSummarizeEvents(t *testing.T) {
+	events := []Event{
+		{Category: "db", Duration: 2 * time.Millisecond},
+		{Category: "db", Duration: 3 * time.Millisecond},
+		{Category: "git", Duration: 10 * time.Millisecond},
+	}
+
+	summary := SummarizeEvents(events)
+	if len(summary) != 2 {
+		t.Fatalf("got %d categories, want 2", len(summary))
+	}
+	if db := summary["db"]; db.Count != 2 || db.Duration != 5*time.Millisecond {
+		t.Fatalf("summary[db] = %+v, want Count=2 Duration=5ms", db)
+	}
+	if git := summary["git"]; git.Count != 1 || git.Duration != 10*time.Millisecond {
+		t.Fatalf("summary[git] = %+v, want Count=1 Duration=10ms", git)
+	}
+}
+
+func TestLogRequestEvents_EmptyIsNoop(t *testing.T) {
+	// Must not panic, and must not be a slow-request log just because the
+	// event list is empty.
+	logRequestEvents("test", nil)
+}
+
+func TestLogRequestEvents_FastAndSlow(t *testing.T) {
+	fast := []Event{{Category: "db", Duration: time.Millisecond}}
+	logRequestEvents("test-fast", fast)
+
+	slow := []Event{{Category: "db", Duration: slowRequestEventsThreshold + time.Second}}
+	logRequestEvents("test-slow", slow)
+}
@@ -0,0 +1,111 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package reqctx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Quota dimensions tracked for the lifetime of a request.
+const (
+	DimensionBytesRead     = "bytes_read"
+	DimensionDBQueries     = "db_queries"
+	DimensionSubprocesses  = "subprocesses"
+	DimensionCPUTimeMs     = "cpu_time_ms"
+	DimensionTempFileBytes = "temp_file_bytes"
+)
+
+// ErrQuotaExceeded is returned by Meter when a configured Limits dimension has
+// been exceeded for the current request.
+var ErrQuotaExceeded = errors.New("reqctx: quota exceeded")
+
+// Limits configures the per-request resource quotas enforced by Meter. A zero
+// value for a field means that dimension is unlimited. Limits are normally
+// installed by middleware, populated from the authenticated user/tenant.
+type Limits struct {
+	MaxBytesRead     int64
+	MaxDBQueries     int64
+	MaxSubprocesses  int64
+	MaxCPUTimeMs     int64
+	MaxTempFileBytes int64
+}
+
+func (l Limits) max(dimension string) (limit int64, known bool) {
+	switch dimension {
+	case DimensionBytesRead:
+		return l.MaxBytesRead, true
+	case DimensionDBQueries:
+		return l.MaxDBQueries, true
+	case DimensionSubprocesses:
+		return l.MaxSubprocesses, true
+	case DimensionCPUTimeMs:
+		return l.MaxCPUTimeMs, true
+	case DimensionTempFileBytes:
+		return l.MaxTempFileBytes, true
+	default:
+		return 0, false
+	}
+}
+
+// Sized is implemented by io.Closer values that know their own size in bytes
+// (e.g. a temp file or a buffered git object reader). AddCloser meters them
+// against DimensionTempFileBytes automatically, so callers don't need to call
+// Meter themselves for every temp file they open. This only records usage
+// after the fact; it does not prevent c from having been created, so it
+// cannot by itself stop a caller from exceeding a quota.
+type Sized interface {
+	Size() int64
+}
+
+type quota struct {
+	mu       sync.Mutex
+	limits   *Limits
+	counters map[string]int64
+}
+
+// WithLimits installs resource limits for the current request. It is a no-op
+// if ctx carries no RequestDataStore.
+func WithLimits(ctx context.Context, limits Limits) {
+	if store := getDataStore(ctx); store != nil {
+		store.quota.mu.Lock()
+		store.quota.limits = &limits
+		store.quota.mu.Unlock()
+	}
+}
+
+// Meter increments the counter for dimension by delta and returns
+// ErrQuotaExceeded (wrapped with details) if doing so pushes the counter past
+// a configured Limits value for that dimension. It is a no-op (always nil
+// error) if ctx carries no RequestDataStore, so it's safe to call from
+// background jobs that have no per-request quota.
+func Meter(ctx context.Context, dimension string, delta int64) error {
+	store := getDataStore(ctx)
+	if store == nil {
+		return nil
+	}
+	return store.quota.meter(dimension, delta)
+}
+
+func (q *quota) meter(dimension string, delta int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.counters == nil {
+		q.counters = make(map[string]int64)
+	}
+	q.counters[dimension] += delta
+	if q.limits == nil {
+		return nil
+	}
+	limit, known := q.limits.max(dimension)
+	if !known || limit <= 0 {
+		return nil
+	}
+	if q.counters[dimension] > limit {
+		return fmt.Errorf("%w: dimension %q limit %d exceeded (current %d)", ErrQuotaExceeded, dimension, limit, q.counters[dimension])
+	}
+	return nil
+}
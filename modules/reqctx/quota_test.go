@@ -0,0 +1,40 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package reqctx
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMeter_LimitExceeded(t *testing.T) {
+	ctx := NewRequestContextForTest(context.Background())
+	WithLimits(ctx, Limits{MaxDBQueries: 2})
+
+	if err := Meter(ctx, DimensionDBQueries, 1); err != nil {
+		t.Fatalf("Meter under limit: %v", err)
+	}
+	if err := Meter(ctx, DimensionDBQueries, 1); err != nil {
+		t.Fatalf("Meter at limit: %v", err)
+	}
+	if err := Meter(ctx, DimensionDBQueries, 1); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Meter over limit = %v, want ErrQuotaExceeded", err)
+	}
+}
+
+func TestMeter_UnlimitedDimension(t *testing.T) {
+	ctx := NewRequestContextForTest(context.Background())
+	WithLimits(ctx, Limits{MaxDBQueries: 1})
+
+	if err := Meter(ctx, DimensionBytesRead, 1<<30); err != nil {
+		t.Fatalf("Meter on a dimension with no configured limit: %v", err)
+	}
+}
+
+func TestMeter_NoRequestDataStore(t *testing.T) {
+	if err := Meter(context.Background(), DimensionDBQueries, 1<<30); err != nil {
+		t.Fatalf("Meter without a RequestDataStore should be a no-op, got: %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package reqctx
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"code.gitea.io/gitea/modules/log"
+)
+
+// slowSpanTreeThreshold is the total request duration above which the span
+// tree is logged in full; cheap (fast) requests discard their spans silently.
+const slowSpanTreeThreshold = 5 * time.Second
+
+// Attr is a single key/value attribute attached to a Span.
+type Attr struct {
+	Key   string
+	Value any
+}
+
+// Span is one node in a request's trace tree. Spans nest based on the
+// "current span" stored on the context passed to StartSpan, so a goroutine
+// spawned mid-request attaches its own children by using the context it was
+// handed, not some goroutine-local state.
+type Span struct {
+	Name     string
+	Start    time.Time
+	Duration time.Duration
+	Attrs    []Attr
+
+	mu       sync.Mutex
+	Children []*Span
+}
+
+func (s *Span) addChild(child *Span) {
+	s.mu.Lock()
+	s.Children = append(s.Children, child)
+	s.mu.Unlock()
+}
+
+func (s *Span) setDuration(d time.Duration) {
+	s.mu.Lock()
+	s.Duration = d
+	s.mu.Unlock()
+}
+
+type currentSpanKeyType struct{}
+
+var currentSpanKey currentSpanKeyType
+
+// rootSpanSlicePool recycles the per-request slice of root spans, keeping
+// allocation overhead near zero for the common case of a short request.
+var rootSpanSlicePool = sync.Pool{
+	New: func() any {
+		s := make([]*Span, 0, 8)
+		return &s
+	},
+}
+
+type spanTracker struct {
+	mu    sync.Mutex
+	roots *[]*Span
+}
+
+func (t *spanTracker) addRoot(s *Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.roots == nil {
+		t.roots, _ = rootSpanSlicePool.Get().(*[]*Span)
+	}
+	*t.roots = append(*t.roots, s)
+}
+
+// takeRoots returns a copy of the accumulated root spans and returns the
+// backing slice to the pool for reuse by the next request. It copies rather
+// than handing back the pooled array itself: once released, that array's
+// next user (another request's addRoot) appends into it, which would
+// silently overwrite elements of the slice we already returned to our
+// caller. Only safe to call once, at request end.
+func (t *spanTracker) takeRoots() []*Span {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.roots == nil {
+		return nil
+	}
+	roots := append([]*Span(nil), (*t.roots)...)
+	*t.roots = (*t.roots)[:0]
+	rootSpanSlicePool.Put(t.roots)
+	t.roots = nil
+	return roots
+}
+
+// StartSpan begins a new span named name, nested under whatever span is
+// current on ctx (or as a new root, if ctx has no current span but does carry
+// a RequestDataStore). It returns a derived context carrying the new span as
+// current, and an end func to be called (typically deferred) when the span
+// finishes.
+func StartSpan(ctx context.Context, name string, attrs ...Attr) (context.Context, func()) {
+	span := &Span{Name: name, Start: time.Now(), Attrs: attrs}
+
+	if parent, _ := ctx.Value(currentSpanKey).(*Span); parent != nil {
+		parent.addChild(span)
+	} else if store := getDataStore(ctx); store != nil {
+		store.spans.addRoot(span)
+	}
+
+	otelCtx, endOTel := startOTelSpan(ctx, name, attrs)
+	spanCtx := context.WithValue(otelCtx, currentSpanKey, span)
+	return spanCtx, func() {
+		span.setDuration(time.Since(span.Start))
+		endOTel()
+	}
+}
+
+// logRequestSpans emits the request's span tree if it ran long enough to be
+// worth a closer look; otherwise the spans are discarded without formatting them.
+func logRequestSpans(profDesc string, roots []*Span, total time.Duration) {
+	if len(roots) == 0 || total < slowSpanTreeThreshold {
+		return
+	}
+	var b strings.Builder
+	for _, root := range roots {
+		writeSpanTree(&b, root, 0)
+	}
+	log.Warn("reqctx: %s was slow (%v), span tree:\n%s", profDesc, total, b.String())
+}
+
+func writeSpanTree(b *strings.Builder, s *Span, depth int) {
+	// s.Duration and s.Children can still be written concurrently by a
+	// goroutine that outlives the request (it holds a context derived from
+	// one of our ancestors and can still be running StartSpan/end), so read
+	// both under s.mu rather than touching the fields directly. s.Name and
+	// s.Attrs are set once at construction, before the span is published to
+	// any other goroutine, so they don't need the same treatment.
+	s.mu.Lock()
+	duration := s.Duration
+	children := append([]*Span(nil), s.Children...)
+	s.mu.Unlock()
+
+	fmt.Fprintf(b, "%s%s (%v)", strings.Repeat("  ", depth), s.Name, duration)
+	for _, a := range s.Attrs {
+		fmt.Fprintf(b, " %s=%v", a.Key, a.Value)
+	}
+	b.WriteByte('\n')
+
+	for _, child := range children {
+		writeSpanTree(b, child, depth+1)
+	}
+}
+
+// Tracer is the minimal interface reqctx needs from an OpenTelemetry
+// TracerProvider, kept narrow so this package doesn't need to import
+// go.opentelemetry.io/otel directly. Install one with SetTracer; until then,
+// spans are only ever kept in-process.
+//
+// Start must return a context derived from ctx that carries the new OTel
+// span, the same way go.opentelemetry.io/otel/trace.Tracer.Start does - the
+// returned context is what gets passed to the next nested StartSpan call, so
+// returning ctx unchanged would make every child show up as its own root in
+// the external TracerProvider instead of nesting under its parent.
+type Tracer interface {
+	Start(ctx context.Context, spanName string, attrs ...Attr) (context.Context, func())
+}
+
+var otelTracer Tracer
+
+// SetTracer installs the Tracer that StartSpan feeds spans to in addition to
+// the in-process span tree, so the same instrumentation serves local
+// debugging and production tracing at once. Passing nil disables it.
+func SetTracer(t Tracer) {
+	otelTracer = t
+}
+
+func startOTelSpan(ctx context.Context, name string, attrs []Attr) (context.Context, func()) {
+	if otelTracer == nil {
+		return ctx, func() {}
+	}
+	return otelTracer.Start(ctx, name, attrs...)
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package reqctx
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStartSpan_Nesting(t *testing.T) {
+	ctx := NewRequestContextForTest(context.Background())
+	store := getDataStore(ctx)
+
+	rootCtx, endRoot := StartSpan(ctx, "root")
+	_, endChild := StartSpan(rootCtx, "child")
+	endChild()
+	endRoot()
+
+	roots := store.spans.takeRoots()
+	if len(roots) != 1 || roots[0].Name != "root" {
+		t.Fatalf("roots = %+v, want a single root span named %q", roots, "root")
+	}
+	if len(roots[0].Children) != 1 || roots[0].Children[0].Name != "child" {
+		t.Fatalf("root.Children = %+v, want a single child named %q", roots[0].Children, "child")
+	}
+}
+
+// TestSpanTracker_TakeRootsSurvivesPoolReuse guards against takeRoots handing
+// back a slice that shares a backing array with rootSpanSlicePool: once that
+// array is reused by the next request's addRoot, it must not silently
+// overwrite spans an earlier caller already read.
+func TestSpanTracker_TakeRootsSurvivesPoolReuse(t *testing.T) {
+	var first, second spanTracker
+	first.addRoot(&Span{Name: "req1-root"})
+	roots1 := first.takeRoots()
+
+	second.addRoot(&Span{Name: "req2-root"})
+	second.takeRoots()
+
+	if len(roots1) != 1 || roots1[0].Name != "req1-root" {
+		t.Fatalf("roots1 = %+v, want a single span named %q untouched by the later request", roots1, "req1-root")
+	}
+}
+
+// TestWriteSpanTree_ConcurrentChildren guards against writeSpanTree reading
+// Span.Children and Span.Duration without the lock addChild/setDuration use
+// - exactly the "goroutine spawned mid-request attaches its own child" case
+// StartSpan is meant to support, racing against the request-end log.
+func TestWriteSpanTree_ConcurrentChildren(t *testing.T) {
+	root := &Span{Name: "root"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			child := &Span{Name: "child"}
+			root.addChild(child)
+			child.setDuration(time.Millisecond)
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var b strings.Builder
+		for i := 0; i < 50; i++ {
+			root.setDuration(time.Duration(i) * time.Millisecond)
+			writeSpanTree(&b, root, 0)
+		}
+	}()
+
+	wg.Wait()
+	<-done
+}
+
+type fakeOTelSpan struct {
+	name, parent string
+}
+
+type fakeOTelTracer struct {
+	spans *[]fakeOTelSpan
+}
+
+type fakeOTelSpanKeyType struct{}
+
+var fakeOTelSpanKey fakeOTelSpanKeyType
+
+func (f fakeOTelTracer) Start(ctx context.Context, name string, _ ...Attr) (context.Context, func()) {
+	parent, _ := ctx.Value(fakeOTelSpanKey).(string)
+	*f.spans = append(*f.spans, fakeOTelSpan{name: name, parent: parent})
+	return context.WithValue(ctx, fakeOTelSpanKey, name), func() {}
+}
+
+// TestStartSpan_ThreadsOTelContext guards against StartSpan discarding the
+// context otelTracer.Start hands back: without threading it through, a
+// nested StartSpan call would feed the OTel tracer a context with no
+// knowledge of the parent span, and "child" below would show up with no
+// parent instead of parented under "root".
+func TestStartSpan_ThreadsOTelContext(t *testing.T) {
+	var spans []fakeOTelSpan
+	SetTracer(fakeOTelTracer{spans: &spans})
+	defer SetTracer(nil)
+
+	ctx := NewRequestContextForTest(context.Background())
+	rootCtx, endRoot := StartSpan(ctx, "root")
+	_, endChild := StartSpan(rootCtx, "child")
+	endChild()
+	endRoot()
+
+	if len(spans) != 2 {
+		t.Fatalf("got %d otel spans, want 2", len(spans))
+	}
+	if spans[0].name != "root" || spans[0].parent != "" {
+		t.Fatalf("spans[0] = %+v, want %q with no parent", spans[0], "root")
+	}
+	if spans[1].name != "child" || spans[1].parent != "root" {
+		t.Fatalf("spans[1] = %+v, want %q parented under %q", spans[1], "child", "root")
+	}
+}